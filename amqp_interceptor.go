@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/tanopwan/go-tcp-proxy/amqphelper"
+)
+
+const (
+	amqpFrameHeaderLen  = 7
+	amqpFrameTrailerLen = 1
+)
+
+// amqpInterceptor reproduces the proxy's original fault-injection
+// behaviour -- rewriting Basic.Ack frames to Basic.Nack -- on top of the
+// general Interceptor framework. It is registered under the name
+// "amqp-nack".
+//
+// Unlike the original AMQPProxy.pipe, it scans for a complete frame (using
+// the length declared in the frame header) before touching it, so a frame
+// split across two reads is reassembled instead of silently passed
+// through unrewritten.
+type amqpInterceptor struct {
+	scanners [2]*FrameScanner
+}
+
+func newAMQPInterceptor() Interceptor {
+	return &amqpInterceptor{
+		scanners: [2]*FrameScanner{NewFrameScanner(), NewFrameScanner()},
+	}
+}
+
+// Intercept implements Interceptor.
+func (a *amqpInterceptor) Intercept(dir Direction, in []byte, out io.Writer) (int, error) {
+	err := a.scanners[dir].Feed(in, func(buf []byte) (int, error) {
+		return parseAMQPFrame(buf, out)
+	})
+	return len(in), err
+}
+
+// parseAMQPFrame reads a single AMQP frame off the front of buf. If buf
+// doesn't yet hold a whole frame it returns ErrIncomplete so the
+// FrameScanner waits for more bytes. A Basic.Ack method frame is rewritten
+// to Basic.Nack; anything else (other method frames, header/body/heartbeat
+// frames, or frames this proxy doesn't understand) is forwarded
+// byte-for-byte.
+func parseAMQPFrame(buf []byte, out io.Writer) (int, error) {
+	if len(buf) < amqpFrameHeaderLen {
+		return 0, ErrIncomplete
+	}
+	size := binary.BigEndian.Uint32(buf[3:7])
+	frameLen := amqpFrameHeaderLen + int(size) + amqpFrameTrailerLen
+	if len(buf) < frameLen {
+		return 0, ErrIncomplete
+	}
+	frame := buf[:frameLen]
+
+	fr := amqphelper.NewFrameReader(bytes.NewReader(frame))
+	if err := fr.ReadFrame(); err == nil {
+		fr.UpdateMethodAckToNack()
+		var rewritten bytes.Buffer
+		if err := fr.Write(&rewritten); err != nil {
+			return 0, err
+		}
+		if _, err := out.Write(rewritten.Bytes()); err != nil {
+			return 0, err
+		}
+		return frameLen, nil
+	}
+
+	if _, err := out.Write(frame); err != nil {
+		return 0, err
+	}
+	return frameLen, nil
+}
@@ -3,23 +3,29 @@ package proxy
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"io"
 	"net"
-
-	"github.com/tanopwan/go-tcp-proxy/amqphelper"
+	"sync"
 )
 
-// AMQPProxy - Manages a Proxy connection, piping data between local and remote, and support AMQP frame
+// AMQPProxy - Manages a Proxy connection, piping data between local and
+// remote, rewriting Basic.Ack frames to Basic.Nack via the built-in
+// "amqp-nack" Interceptor. It is a convenience wrapper around Proxy for
+// that one behaviour; for anything else (other interceptors, chaining,
+// custom protocols) use Proxy directly with Interceptors set.
 type AMQPProxy struct {
 	sentBytes     uint64
 	receivedBytes uint64
 	laddr, raddr  *net.TCPAddr
 	lconn, rconn  io.ReadWriteCloser
-	erred         bool
+	errOnce       sync.Once
 	errsig        chan bool
 	tlsUnwrapp    bool
 	tlsAddress    string
+	clientAddr    string
+	interceptor   Interceptor
 
 	Matcher  func([]byte)
 	Replacer func([]byte) []byte
@@ -28,18 +34,30 @@ type AMQPProxy struct {
 	Nagles    bool
 	Log       Logger
 	OutputHex bool
+
+	// SendProxyProtocol, when not ProxyProtocolOff, writes a PROXY
+	// protocol header to rconn -- describing lconn's remote (client) and
+	// local addresses -- before any application bytes, so the upstream
+	// can recover the original client address.
+	SendProxyProtocol ProxyProtocolVersion
+
+	// AcceptProxyProtocol, when true, expects a PROXY protocol header (v1
+	// or v2, auto-detected) at the front of lconn and strips it before any
+	// application bytes are read; the client address it encodes is used
+	// in place of lconn's immediate peer for logging.
+	AcceptProxyProtocol bool
 }
 
 // NewAMQP - Create a new Proxy instance. Takes over local connection passed in,
 // and closes it when finished.
 func NewAMQP(lconn *net.TCPConn, laddr, raddr *net.TCPAddr) *AMQPProxy {
 	return &AMQPProxy{
-		lconn:  lconn,
-		laddr:  laddr,
-		raddr:  raddr,
-		erred:  false,
-		errsig: make(chan bool),
-		Log:    NullLogger{},
+		lconn:       lconn,
+		laddr:       laddr,
+		raddr:       raddr,
+		errsig:      make(chan bool),
+		interceptor: newAMQPInterceptor(),
+		Log:         NullLogger{},
 	}
 }
 
@@ -53,11 +71,37 @@ func NewAMQPTLSUnwrapped(lconn *net.TCPConn, laddr, raddr *net.TCPAddr, addr str
 	return p
 }
 
-// Start - open connection to remote and start proxying data.
-func (p *AMQPProxy) Start() {
+// Start - open connection to remote and start proxying data. Start blocks
+// until the proxy is done: either ctx is cancelled, or one of the
+// connections is closed or errors. If a PROXY protocol header cannot be
+// read or written, or the remote cannot be dialed, it returns that error
+// without proxying. Otherwise it returns ctx.Err(): non-nil if ctx was
+// cancelled, nil if not.
+func (p *AMQPProxy) Start(ctx context.Context) error {
 	defer p.lconn.Close()
 
+	srcAddr, dstAddr := connAddrs(p.lconn)
+
 	var err error
+	if p.AcceptProxyProtocol {
+		br := bufio.NewReader(p.lconn)
+		hdrSrc, hdrDst, herr := readProxyProtocolHeader(br)
+		if herr != nil {
+			p.Log.Warn("Failed to read PROXY protocol header: %s", herr)
+			return herr
+		}
+		p.lconn = &bufReadWriteCloser{Reader: br, wc: p.lconn}
+		if hdrSrc != nil {
+			srcAddr = hdrSrc
+		}
+		if hdrDst != nil {
+			dstAddr = hdrDst
+		}
+	}
+	if srcAddr != nil {
+		p.clientAddr = srcAddr.String()
+	}
+
 	//connect to remote
 	if p.tlsUnwrapp {
 		p.rconn, err = tls.Dial("tcp", p.tlsAddress, nil)
@@ -66,10 +110,17 @@ func (p *AMQPProxy) Start() {
 	}
 	if err != nil {
 		p.Log.Warn("Remote connection failed: %s", err)
-		return
+		return err
 	}
 	defer p.rconn.Close()
 
+	if p.SendProxyProtocol != ProxyProtocolOff {
+		if err := writeProxyProtocolHeader(p.rconn, p.SendProxyProtocol, srcAddr, dstAddr); err != nil {
+			p.Log.Warn("Failed to write PROXY protocol header: %s", err)
+			return err
+		}
+	}
+
 	//nagles?
 	if p.Nagles {
 		if conn, ok := p.lconn.(setNoDelayer); ok {
@@ -81,7 +132,25 @@ func (p *AMQPProxy) Start() {
 	}
 
 	//display both ends
-	p.Log.Info("Opened %s >>> %s", p.laddr.String(), p.raddr.String())
+	if p.clientAddr != "" {
+		p.Log.Info("Opened %s >>> %s (client %s)", p.laddr.String(), p.raddr.String(), p.clientAddr)
+	} else {
+		p.Log.Info("Opened %s >>> %s", p.laddr.String(), p.raddr.String())
+	}
+
+	//ctx.Done() unblocks the in-flight Reads below by closing both
+	//connections; done stops this goroutine once the proxy has finished on
+	//its own.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.lconn.Close()
+			p.rconn.Close()
+		case <-done:
+		}
+	}()
 
 	//bidirectional copy
 	go p.pipe(p.lconn, p.rconn)
@@ -90,24 +159,40 @@ func (p *AMQPProxy) Start() {
 	//wait for close...
 	<-p.errsig
 	p.Log.Info("Closed (%d bytes sent, %d bytes recieved)", p.sentBytes, p.receivedBytes)
+
+	return ctx.Err()
 }
 
-func (p *AMQPProxy) err(s string, err error) {
-	if p.erred {
-		return
-	}
-	if err != io.EOF {
-		p.Log.Warn(s, err)
+// StartBackground - back-compat wrapper around Start for callers that do
+// not need to control the proxy's lifecycle. It runs with
+// context.Background() (i.e. never cancelled) and logs any resulting error.
+func (p *AMQPProxy) StartBackground() {
+	if err := p.Start(context.Background()); err != nil {
+		p.Log.Warn("Proxy stopped: %s", err)
 	}
-	p.errsig <- true
-	p.erred = true
+}
+
+// err reports a pipe failure and signals Start to return. Both pipe
+// goroutines (and the ctx.Done() watcher, indirectly, by closing the
+// connections they read from) can call this concurrently, so only the
+// first call runs: errsig is unbuffered and Start only ever receives
+// from it once.
+func (p *AMQPProxy) err(s string, err error) {
+	p.errOnce.Do(func() {
+		if err != io.EOF {
+			p.Log.Warn(s, err)
+		}
+		p.errsig <- true
+	})
 }
 
 func (p *AMQPProxy) pipe(src, dst io.ReadWriter) {
 	islocal := src == p.lconn
 
+	dir := ServerToClient
 	var dataDirection string
 	if islocal {
+		dir = ClientToServer
 		dataDirection = ">>> %d bytes sent%s"
 	} else {
 		dataDirection = "<<< %d bytes received%s"
@@ -120,8 +205,9 @@ func (p *AMQPProxy) pipe(src, dst io.ReadWriter) {
 		byteFormat = "%s"
 	}
 
-	//directional copy (64k buffer)
-	buff := make([]byte, 0xffff)
+	//directional copy (64k buffer, pooled across connections)
+	buff := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buff)
 	for {
 		n, err := src.Read(buff)
 		if err != nil {
@@ -144,27 +230,14 @@ func (p *AMQPProxy) pipe(src, dst io.ReadWriter) {
 		p.Log.Debug(dataDirection, n, "")
 		p.Log.Trace(byteFormat, b)
 
-		buf := bufio.NewReader(bytes.NewReader(buff))
-		frameReader := amqphelper.NewFrameReader(buf)
-		err = frameReader.ReadFrame()
-		if err == nil {
-			p.Log.Debug("found Basic ACK -> replace with NACK")
-			frameReader.UpdateMethodAckToNack()
-			err = frameReader.Write(dst)
-			if err != nil {
-				p.Log.Warn(err.Error())
-				return
-			}
-
-			if islocal {
-				p.sentBytes += uint64(n)
-			} else {
-				p.receivedBytes += uint64(n)
-			}
-			continue
-		}
-		if err != nil {
+		var rewritten bytes.Buffer
+		if _, err := p.interceptor.Intercept(dir, b, &rewritten); err != nil {
 			p.Log.Warn(err.Error())
+			return
+		}
+		b = rewritten.Bytes()
+		if len(b) == 0 {
+			continue
 		}
 
 		//write out result
@@ -0,0 +1,16 @@
+package proxy
+
+import "sync"
+
+// pipeBufferSize matches the original hard-coded 64 KiB per-direction
+// buffer size.
+const pipeBufferSize = 0xffff
+
+// bufferPool recycles the 64 KiB buffers pipe uses to shuttle bytes
+// between connections, avoiding a fresh allocation per connection per
+// direction.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, pipeBufferSize)
+	},
+}
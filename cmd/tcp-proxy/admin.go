@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// serveAdmin runs the opt-in admin HTTP API until ctx is cancelled. It
+// exposes /connections (list active connections), /reload (re-read the
+// config and apply it via srv.Reload) and /stop/{connid} (force-close a
+// connection).
+func serveAdmin(ctx context.Context, wg *sync.WaitGroup, addr string, srv *Server) error {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/connections", func(w http.ResponseWriter, r *http.Request) {
+		handleConnections(w, srv)
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		handleReload(w, ctx, wg, srv)
+	})
+	mux.HandleFunc("/stop/", func(w http.ResponseWriter, r *http.Request) {
+		handleStop(w, r, srv)
+	})
+
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+
+	logger.Info("Admin API listening on %s", addr)
+	err := httpServer.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+type connectionJSON struct {
+	ID            uint64 `json:"id"`
+	ClientAddr    string `json:"client_addr"`
+	RemoteAddr    string `json:"remote_addr"`
+	SentBytes     uint64 `json:"sent_bytes"`
+	ReceivedBytes uint64 `json:"received_bytes"`
+	MatchedRules  uint64 `json:"matched_rules"`
+	DurationMS    int64  `json:"duration_ms"`
+}
+
+func handleConnections(w http.ResponseWriter, srv *Server) {
+	conns := srv.Connections()
+	out := make([]connectionJSON, 0, len(conns))
+	for id, c := range conns {
+		out = append(out, connectionJSON{
+			ID:            id,
+			ClientAddr:    c.ClientAddr,
+			RemoteAddr:    c.RemoteAddr,
+			SentBytes:     c.SentBytes,
+			ReceivedBytes: c.ReceivedBytes,
+			MatchedRules:  c.MatchedRules,
+			DurationMS:    c.Duration.Milliseconds(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func handleReload(w http.ResponseWriter, ctx context.Context, wg *sync.WaitGroup, srv *Server) {
+	specs, err := buildListenerSpecs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := srv.Reload(ctx, wg, specs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "reloaded %d listener(s)\n", len(specs))
+}
+
+func handleStop(w http.ResponseWriter, r *http.Request, srv *Server) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/stop/")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid connection id", http.StatusBadRequest)
+		return
+	}
+	if !srv.Stop(id) {
+		http.Error(w, "no such connection", http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "stopped connection #%d\n", id)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the top-level structure of a -config YAML file: a set of
+// independent listeners, each proxying its own local port to its own
+// target.
+type Config struct {
+	Listen []ListenConfig `yaml:"listen"`
+}
+
+// ListenConfig describes one entry under the top-level `listen` key.
+//
+// TargetHost may be a single hostname or IP, or an IP range such as
+// "10.0.1.2-250"; a range expands into one listener per address in it,
+// via Expand, with ListenPort auto-incrementing from the entry's own
+// port for each address.
+type ListenConfig struct {
+	ListenPort int    `yaml:"listen_port"`
+	TargetHost string `yaml:"target_host"`
+	TargetPort int    `yaml:"target_port"`
+	UnwrapTLS  bool   `yaml:"unwrap_tls"`
+	Match      string `yaml:"match"`
+	Replace    string `yaml:"replace"`
+	// Verbosity is 0 (quiet), 1 (verbose) or 2 (very verbose, logs data).
+	Verbosity int `yaml:"verbosity"`
+	// Rules are evaluated, in order, against this listener's traffic;
+	// see RuleConfig. They run in addition to Match/Replace.
+	Rules []RuleConfig `yaml:"rules"`
+	// Sniff routes connections to a different remote based on their
+	// detected protocol/hostname, falling back to TargetHost:TargetPort
+	// when nothing matches; see SniffRouteConfig.
+	Sniff []SniffRouteConfig `yaml:"sniff"`
+}
+
+// LoadConfig reads and parses a -config YAML file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+var hostRangeRe = regexp.MustCompile(`^(\d+\.\d+\.\d+\.)(\d+)-(\d+)$`)
+
+// Expand turns a ListenConfig whose TargetHost is an IP range
+// ("a.b.c.start-end") into one ListenConfig per address in the range. A
+// plain hostname or IP expands to a single-element slice containing l
+// itself, unresolved -- hostnames are looked up at accept time, not here,
+// so DNS changes are picked up without a restart.
+func (l ListenConfig) Expand() ([]ListenConfig, error) {
+	m := hostRangeRe.FindStringSubmatch(l.TargetHost)
+	if m == nil {
+		return []ListenConfig{l}, nil
+	}
+
+	prefix := m[1]
+	start, err := strconv.Atoi(m[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid target_host range %q: %w", l.TargetHost, err)
+	}
+	end, err := strconv.Atoi(m[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid target_host range %q: %w", l.TargetHost, err)
+	}
+	if end < start {
+		return nil, fmt.Errorf("invalid target_host range %q: end before start", l.TargetHost)
+	}
+
+	expanded := make([]ListenConfig, 0, end-start+1)
+	for i, octet := 0, start; octet <= end; i, octet = i+1, octet+1 {
+		e := l
+		e.TargetHost = fmt.Sprintf("%s%d", prefix, octet)
+		e.ListenPort = l.ListenPort + i
+		expanded = append(expanded, e)
+	}
+	return expanded, nil
+}
+
+// ExpandAll expands every entry in c.Listen, in order, concatenating the
+// results.
+func (c *Config) ExpandAll() ([]ListenConfig, error) {
+	var all []ListenConfig
+	for _, l := range c.Listen {
+		expanded, err := l.Expand()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, expanded...)
+	}
+	return all, nil
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	proxy "github.com/jpillora/go-tcp-proxy"
 )
@@ -17,83 +24,212 @@ var (
 	connid  = uint64(0)
 	logger  proxy.ColorLogger
 
-	localAddr   = flag.String("l", ":9999", "local address")
-	remoteAddr  = flag.String("r", "localhost:80", "remote address")
-	verbose     = flag.Bool("v", false, "display server actions")
-	veryverbose = flag.Bool("vv", false, "display server actions and all tcp data")
-	nagles      = flag.Bool("n", false, "disable nagles algorithm")
-	hex         = flag.Bool("h", false, "output hex")
-	colors      = flag.Bool("c", false, "output ansi colors")
-	unwrapTLS   = flag.Bool("unwrap-tls", false, "remote connection with TLS exposed unencrypted locally")
-	match       = flag.String("match", "", "match regex (in the form 'regex')")
-	replace     = flag.String("replace", "", "replace regex (in the form '/regex1/replacer1/regex2/replace2/' if / is delimiter)")
+	configPath          = flag.String("config", "", "path to a YAML config file describing one or more listeners; overrides -l/-r/-match/-replace")
+	localAddr           = flag.String("l", ":9999", "local address")
+	remoteAddr          = flag.String("r", "localhost:80", "remote address")
+	verbose             = flag.Bool("v", false, "display server actions")
+	veryverbose         = flag.Bool("vv", false, "display server actions and all tcp data")
+	nagles              = flag.Bool("n", false, "disable nagles algorithm")
+	hex                 = flag.Bool("h", false, "output hex")
+	colors              = flag.Bool("c", false, "output ansi colors")
+	unwrapTLS           = flag.Bool("unwrap-tls", false, "remote connection with TLS exposed unencrypted locally")
+	match               = flag.String("match", "", "match regex (in the form 'regex')")
+	replace             = flag.String("replace", "", "replace regex (in the form '/regex1/replacer1/regex2/replace2/' if / is delimiter)")
+	intercept           = flag.String("intercept", "", "comma-separated list of interceptors to chain, e.g. 'amqp-nack'")
+	rulesPath           = flag.String("rules", "", "path to a YAML file of rules (match/respond/replace/drop/close) to evaluate against traffic; ignored when -config is set, where each listener has its own rules")
+	sniffBytes          = flag.Int("sniff-bytes", 2048, "number of bytes to peek when sniffing a connection's protocol before dialing the remote")
+	sniffTimeout        = flag.Duration("sniff-timeout", 200*time.Millisecond, "maximum time to wait for enough bytes to sniff a connection's protocol before falling back to the default remote")
+	proxyProtocol       = flag.String("proxy-protocol", "", "send a PROXY protocol header to the remote before any application data: off, v1 or v2")
+	acceptProxyProtocol = flag.Bool("accept-proxy-protocol", false, "expect a PROXY protocol header (v1 or v2, auto-detected) on accepted connections and use it as the client address")
+	shutdownTimeout     = flag.Duration("shutdown-timeout", 5*time.Second, "maximum time to wait for in-flight connections to close on shutdown")
+	adminAddr           = flag.String("admin", "", "address for an opt-in admin HTTP API (/connections, /reload, /stop/{connid}); empty disables it")
 )
 
+// listenerSpec is a fully resolved description of one local port to
+// proxy, built either from a single ListenConfig entry (-config mode) or
+// from the flat CLI flags (the -config-less shortcut).
+type listenerSpec struct {
+	listenAddr  string
+	targetHost  string
+	targetPort  int
+	unwrapTLS   bool
+	matcher     func([]byte)
+	replacer    func([]byte) []byte
+	rules       []*proxy.Rule
+	sniffRoutes []proxy.SniffRoute
+	verbose     bool
+	veryverbose bool
+}
+
 func main() {
 	flag.Parse()
 
-	logger := proxy.ColorLogger{
+	logger = proxy.ColorLogger{
 		Verbose: *verbose,
 		Color:   *colors,
 	}
 
-	logger.Info("go-tcp-proxy (%s) proxing from %v to %v ", version, *localAddr, *remoteAddr)
+	logger.Info("go-tcp-proxy (%s) starting", version)
 
-	laddr, err := net.ResolveTCPAddr("tcp", *localAddr)
+	proxyProtocolVersion, err := proxy.ParseProxyProtocolVersion(*proxyProtocol)
 	if err != nil {
-		logger.Warn("Failed to resolve local address: %s", err)
+		logger.Warn("%s", err)
 		os.Exit(1)
 	}
-	raddr, err := net.ResolveTCPAddr("tcp", *remoteAddr)
+
+	interceptNames := parseInterceptNames(*intercept)
+	for _, name := range interceptNames {
+		// fail fast on an unknown name rather than dropping connections later
+		if _, err := proxy.NewInterceptor(name); err != nil {
+			logger.Warn("%s", err)
+			os.Exit(1)
+		}
+	}
+
+	if *veryverbose {
+		*verbose = true
+	}
+
+	specs, err := buildListenerSpecs()
 	if err != nil {
-		logger.Warn("Failed to resolve remote address: %s", err)
+		logger.Warn("%s", err)
 		os.Exit(1)
 	}
-	listener, err := net.ListenTCP("tcp", laddr)
-	if err != nil {
-		logger.Warn("Failed to open local port to listen: %s", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logger.Info("Received %s, shutting down", sig)
+		cancel()
+	}()
+
+	var wg sync.WaitGroup
+	srv := newServer(interceptNames, proxyProtocolVersion)
+	if err := srv.Start(ctx, &wg, specs); err != nil {
+		logger.Warn("%s", err)
 		os.Exit(1)
 	}
 
-	matcher := createMatcher(*match)
-	replacer := createReplacer(*replace)
+	if *adminAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := serveAdmin(ctx, &wg, *adminAddr, srv); err != nil {
+				logger.Warn("Admin API failed: %s", err)
+			}
+		}()
+	}
 
-	if *veryverbose {
-		*verbose = true
+	<-ctx.Done()
+
+	logger.Info("Waiting up to %s for connection(s) to close", *shutdownTimeout)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logger.Info("All connections closed, exiting")
+	case <-time.After(*shutdownTimeout):
+		logger.Warn("Shutdown timeout exceeded, exiting with connections still active")
 	}
+}
 
-	for {
-		conn, err := listener.AcceptTCP()
+// buildListenerSpecs returns one listenerSpec per listener this process
+// should run: the entries of -config, expanded, or a single spec built
+// from -l/-r/-match/-replace when -config is absent.
+func buildListenerSpecs() ([]listenerSpec, error) {
+	if *configPath == "" {
+		host, port, err := splitHostPort(*remoteAddr)
 		if err != nil {
-			logger.Warn("Failed to accept connection '%s'", err)
-			continue
+			return nil, fmt.Errorf("parsing remote address %q: %w", *remoteAddr, err)
 		}
-		connid++
-
-		var p *proxy.Proxy
-		if *unwrapTLS {
-			logger.Info("Unwrapping TLS")
-			p = proxy.NewTLSUnwrapped(conn, laddr, raddr, *remoteAddr)
-		} else {
-			p = proxy.New(conn, laddr, raddr)
+		var rules []*proxy.Rule
+		if *rulesPath != "" {
+			rules, err = LoadRules(*rulesPath)
+			if err != nil {
+				return nil, fmt.Errorf("loading rules %s: %w", *rulesPath, err)
+			}
 		}
+		return []listenerSpec{{
+			listenAddr:  *localAddr,
+			targetHost:  host,
+			targetPort:  port,
+			unwrapTLS:   *unwrapTLS,
+			matcher:     createMatcher(*match),
+			replacer:    createReplacer(*replace),
+			rules:       rules,
+			verbose:     *verbose,
+			veryverbose: *veryverbose,
+		}}, nil
+	}
 
-		p.Matcher = matcher
-		p.Replacer = replacer
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading config %s: %w", *configPath, err)
+	}
+	entries, err := cfg.ExpandAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", *configPath, err)
+	}
 
-		p.Nagles = *nagles
-		p.OutputHex = *hex
-		p.Log = proxy.ColorLogger{
-			Verbose:     *verbose,
-			VeryVerbose: *veryverbose,
-			Prefix:      fmt.Sprintf("Connection #%03d ", connid),
-			Color:       *colors,
+	specs := make([]listenerSpec, 0, len(entries))
+	for _, e := range entries {
+		v, vv := verbosityFlags(e.Verbosity)
+		rules, err := compileRules(e.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("listener :%d: %w", e.ListenPort, err)
 		}
+		sniffRoutes, err := compileSniffRoutes(e.Sniff)
+		if err != nil {
+			return nil, fmt.Errorf("listener :%d: %w", e.ListenPort, err)
+		}
+		specs = append(specs, listenerSpec{
+			listenAddr:  fmt.Sprintf(":%d", e.ListenPort),
+			targetHost:  e.TargetHost,
+			targetPort:  e.TargetPort,
+			unwrapTLS:   e.UnwrapTLS,
+			matcher:     createMatcher(e.Match),
+			replacer:    createReplacer(e.Replace),
+			rules:       rules,
+			sniffRoutes: sniffRoutes,
+			verbose:     v,
+			veryverbose: vv,
+		})
+	}
+	return specs, nil
+}
 
-		go p.Start()
+func verbosityFlags(level int) (verbose, veryverbose bool) {
+	switch {
+	case level >= 2:
+		return true, true
+	case level == 1:
+		return true, false
+	default:
+		return false, false
 	}
 }
 
+func splitHostPort(addr string) (host string, port int, err error) {
+	h, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err = strconv.Atoi(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q: %w", p, err)
+	}
+	return h, port, nil
+}
+
 func createMatcher(match string) func([]byte) {
 	if match == "" {
 		return nil
@@ -108,12 +244,25 @@ func createMatcher(match string) func([]byte) {
 	return func(input []byte) {
 		ms := re.FindAll(input, -1)
 		for _, m := range ms {
-			matchid++
+			atomic.AddUint64(&matchid, 1)
 			logger.Info("Match #%d: %s", matchid, string(m))
 		}
 	}
 }
 
+func parseInterceptNames(intercept string) []string {
+	if intercept == "" {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(intercept, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func createReplacer(replace string) func([]byte) []byte {
 	if replace == "" {
 		return nil
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+
+	proxy "github.com/jpillora/go-tcp-proxy"
+)
+
+// RuleConfig is one entry of a rules file (standalone, via -rules, or
+// inline under a ListenConfig's own `rules` key).
+type RuleConfig struct {
+	Name string `yaml:"name"`
+
+	// Match and MatchContains are mutually exclusive; exactly one
+	// should be set.
+	Match         string `yaml:"match"`
+	MatchContains string `yaml:"match-contains"`
+
+	// Direction is "client->server", "server->client" or "both"
+	// (the default).
+	Direction string `yaml:"direction"`
+
+	// Action is "replace", "respond", "drop" or "close".
+	Action string `yaml:"action"`
+
+	Replace           string `yaml:"replace"`
+	Respond           string `yaml:"respond"`
+	CloseAfterRespond bool   `yaml:"close-after-respond"`
+}
+
+// RulesConfig is the top-level structure of a -rules YAML file.
+type RulesConfig struct {
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+// LoadRules reads and compiles a -rules YAML file.
+func LoadRules(path string) ([]*proxy.Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg RulesConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return compileRules(cfg.Rules)
+}
+
+// compileRules turns a list of RuleConfig, as loaded from YAML, into the
+// *proxy.Rule list a Proxy can evaluate.
+func compileRules(cfgs []RuleConfig) ([]*proxy.Rule, error) {
+	rules := make([]*proxy.Rule, 0, len(cfgs))
+	for _, c := range cfgs {
+		r := &proxy.Rule{
+			Name:              c.Name,
+			CloseAfterRespond: c.CloseAfterRespond,
+		}
+
+		switch {
+		case c.Match != "":
+			re, err := regexp.Compile(c.Match)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: invalid match regex: %w", c.Name, err)
+			}
+			r.Match = re
+		case c.MatchContains != "":
+			r.MatchContains = []byte(c.MatchContains)
+		default:
+			return nil, fmt.Errorf("rule %q: one of match or match-contains is required", c.Name)
+		}
+
+		switch c.Direction {
+		case "", "both":
+			// nil Direction matches both
+		case "client->server":
+			d := proxy.ClientToServer
+			r.Direction = &d
+		case "server->client":
+			d := proxy.ServerToClient
+			r.Direction = &d
+		default:
+			return nil, fmt.Errorf("rule %q: invalid direction %q", c.Name, c.Direction)
+		}
+
+		switch c.Action {
+		case "replace":
+			r.Action = proxy.ActionReplace
+			r.Replacement = []byte(c.Replace)
+		case "respond":
+			r.Action = proxy.ActionRespond
+			r.Response = []byte(c.Respond)
+		case "drop":
+			r.Action = proxy.ActionDrop
+		case "close":
+			r.Action = proxy.ActionClose
+		default:
+			return nil, fmt.Errorf("rule %q: invalid action %q", c.Name, c.Action)
+		}
+
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	proxy "github.com/jpillora/go-tcp-proxy"
+)
+
+// Server owns the live set of listeners and the registry of in-flight
+// connections, with or without -admin. Reload swaps listener
+// specs in place -- so already-accepted connections keep the settings
+// they started with, but newly accepted ones see the reloaded config --
+// starts listeners for addresses that are new, and stops listeners for
+// addresses no longer present (without touching the connections already
+// accepted on them).
+type Server struct {
+	interceptNames       []string
+	proxyProtocolVersion proxy.ProxyProtocolVersion
+
+	mu        sync.Mutex
+	specs     map[string]*listenerSpec
+	listeners map[string]*net.TCPListener
+	cancels   map[string]context.CancelFunc
+
+	connsMu sync.Mutex
+	conns   map[uint64]*connEntry
+}
+
+// connEntry is one entry of Server's connection registry.
+type connEntry struct {
+	proxy     *proxy.Proxy
+	startedAt time.Time
+}
+
+// ConnSnapshot is one /connections entry.
+type ConnSnapshot struct {
+	proxy.ConnInfo
+	Duration time.Duration
+}
+
+func newServer(interceptNames []string, proxyProtocolVersion proxy.ProxyProtocolVersion) *Server {
+	return &Server{
+		interceptNames:       interceptNames,
+		proxyProtocolVersion: proxyProtocolVersion,
+		specs:                make(map[string]*listenerSpec),
+		listeners:            make(map[string]*net.TCPListener),
+		cancels:              make(map[string]context.CancelFunc),
+		conns:                make(map[uint64]*connEntry),
+	}
+}
+
+// Start brings up one listener per spec.
+func (srv *Server) Start(ctx context.Context, wg *sync.WaitGroup, specs []listenerSpec) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for i := range specs {
+		if err := srv.startListenerLocked(ctx, wg, &specs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload re-applies a freshly resolved set of specs (e.g. after
+// re-reading a -config file): listeners whose listenAddr is unchanged
+// get their spec swapped in place, listeners for addresses no longer
+// present are stopped, and new addresses get a fresh listener.
+func (srv *Server) Reload(ctx context.Context, wg *sync.WaitGroup, specs []listenerSpec) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	want := make(map[string]*listenerSpec, len(specs))
+	for i := range specs {
+		want[specs[i].listenAddr] = &specs[i]
+	}
+
+	for addr, listener := range srv.listeners {
+		if _, ok := want[addr]; ok {
+			continue
+		}
+		srv.cancels[addr]()
+		listener.Close()
+		delete(srv.listeners, addr)
+		delete(srv.cancels, addr)
+		delete(srv.specs, addr)
+		logger.Info("Stopped proxying %s", addr)
+	}
+
+	for addr, spec := range want {
+		if _, ok := srv.listeners[addr]; ok {
+			srv.specs[addr] = spec
+			logger.Info("Reloaded %s >>> %s:%d", addr, spec.targetHost, spec.targetPort)
+			continue
+		}
+		if err := srv.startListenerLocked(ctx, wg, spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (srv *Server) startListenerLocked(ctx context.Context, wg *sync.WaitGroup, spec *listenerSpec) error {
+	laddr, err := net.ResolveTCPAddr("tcp", spec.listenAddr)
+	if err != nil {
+		return fmt.Errorf("resolving listen address %q: %w", spec.listenAddr, err)
+	}
+	listener, err := net.ListenTCP("tcp", laddr)
+	if err != nil {
+		return fmt.Errorf("listening on %q: %w", spec.listenAddr, err)
+	}
+
+	// lctx is this listener's own context: it is cancelled both when ctx
+	// is (process shutdown) and when Reload stops this listener, so
+	// acceptConnections' select sees a done channel in either case
+	// instead of looping on the accept error a closed listener returns.
+	lctx, cancel := context.WithCancel(ctx)
+
+	srv.specs[spec.listenAddr] = spec
+	srv.listeners[spec.listenAddr] = listener
+	srv.cancels[spec.listenAddr] = cancel
+
+	// unblocks AcceptTCP below once the listener's context is cancelled
+	go func() {
+		<-lctx.Done()
+		listener.Close()
+	}()
+
+	logger.Info("Proxying %s >>> %s:%d", spec.listenAddr, spec.targetHost, spec.targetPort)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.acceptConnections(ctx, lctx, wg, listener, laddr, spec.listenAddr)
+	}()
+	return nil
+}
+
+func (srv *Server) acceptConnections(ctx, lctx context.Context, wg *sync.WaitGroup, listener *net.TCPListener, laddr *net.TCPAddr, listenAddr string) {
+	for {
+		conn, err := listener.AcceptTCP()
+		if err != nil {
+			select {
+			case <-lctx.Done():
+				return
+			default:
+				logger.Warn("Failed to accept connection on %s '%s'", listenAddr, err)
+				continue
+			}
+		}
+
+		// re-read on every accept (not captured once up front) so a
+		// /reload that swapped this listener's spec takes effect for
+		// the very next connection
+		srv.mu.Lock()
+		spec := srv.specs[listenAddr]
+		srv.mu.Unlock()
+		if spec == nil {
+			// listener was stopped between AcceptTCP and here
+			conn.Close()
+			continue
+		}
+
+		// resolved fresh for every connection, rather than once at
+		// startup, so DNS changes to targetHost take effect without a
+		// restart
+		remoteAddr := fmt.Sprintf("%s:%d", spec.targetHost, spec.targetPort)
+		raddr, err := net.ResolveTCPAddr("tcp", remoteAddr)
+		if err != nil {
+			logger.Warn("Failed to resolve target %s '%s'", remoteAddr, err)
+			conn.Close()
+			continue
+		}
+
+		id := atomic.AddUint64(&connid, 1)
+
+		var p *proxy.Proxy
+		if spec.unwrapTLS {
+			p = proxy.NewTLSUnwrapped(conn, laddr, raddr, nil, nil, remoteAddr)
+		} else {
+			p = proxy.New(conn, laddr, raddr, nil, nil)
+		}
+
+		p.Matcher = spec.matcher
+		p.Replacer = spec.replacer
+		p.Rules = spec.rules
+		p.SniffRoutes = spec.sniffRoutes
+		p.SniffBytes = *sniffBytes
+		p.SniffTimeout = *sniffTimeout
+		// each connection gets its own Interceptor instances, since they
+		// may hold per-connection stream state (e.g. a FrameScanner)
+		for _, name := range srv.interceptNames {
+			ic, err := proxy.NewInterceptor(name)
+			if err != nil {
+				logger.Warn("%s", err)
+				continue
+			}
+			p.Interceptors = append(p.Interceptors, ic)
+		}
+
+		p.Nagles = *nagles
+		p.OutputHex = *hex
+		p.SendProxyProtocol = srv.proxyProtocolVersion
+		p.AcceptProxyProtocol = *acceptProxyProtocol
+		p.Log = proxy.ColorLogger{
+			Verbose:     spec.verbose,
+			VeryVerbose: spec.veryverbose,
+			Prefix:      fmt.Sprintf("Connection #%03d ", id),
+			Color:       *colors,
+		}
+		p.OnStateChange = func(state proxy.ConnState, pr *proxy.Proxy) {
+			switch state {
+			case proxy.ConnOpen:
+				srv.registerConn(id, pr)
+			case proxy.ConnClosed:
+				srv.unregisterConn(id)
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Start(ctx); err != nil {
+				p.Log.Warn("Proxy stopped: %s", err)
+			}
+		}()
+	}
+}
+
+func (srv *Server) registerConn(id uint64, p *proxy.Proxy) {
+	srv.connsMu.Lock()
+	defer srv.connsMu.Unlock()
+	srv.conns[id] = &connEntry{proxy: p, startedAt: time.Now()}
+}
+
+func (srv *Server) unregisterConn(id uint64) {
+	srv.connsMu.Lock()
+	defer srv.connsMu.Unlock()
+	delete(srv.conns, id)
+}
+
+// Connections returns a snapshot of the currently active connections,
+// keyed by connection id.
+func (srv *Server) Connections() map[uint64]ConnSnapshot {
+	srv.connsMu.Lock()
+	defer srv.connsMu.Unlock()
+	out := make(map[uint64]ConnSnapshot, len(srv.conns))
+	for id, e := range srv.conns {
+		out[id] = ConnSnapshot{
+			ConnInfo: e.proxy.Info(),
+			Duration: time.Since(e.startedAt),
+		}
+	}
+	return out
+}
+
+// Stop force-closes the connection with the given id. It reports
+// whether a connection with that id was found.
+func (srv *Server) Stop(id uint64) bool {
+	srv.connsMu.Lock()
+	e, ok := srv.conns[id]
+	srv.connsMu.Unlock()
+	if !ok {
+		return false
+	}
+	e.proxy.Stop()
+	return true
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	proxy "github.com/jpillora/go-tcp-proxy"
+)
+
+// SniffRouteConfig is one entry of a listener's `sniff` list, e.g.
+// {sniff: tls, sni: "*.example.com", remote: "10.0.0.5:443"}.
+type SniffRouteConfig struct {
+	Sniff  string `yaml:"sniff"`
+	SNI    string `yaml:"sni"`
+	Remote string `yaml:"remote"`
+}
+
+// compileSniffRoutes turns a list of SniffRouteConfig, as loaded from
+// YAML, into the []proxy.SniffRoute a Proxy can evaluate.
+func compileSniffRoutes(cfgs []SniffRouteConfig) ([]proxy.SniffRoute, error) {
+	routes := make([]proxy.SniffRoute, 0, len(cfgs))
+	for _, c := range cfgs {
+		var protocol proxy.Protocol
+		switch c.Sniff {
+		case "tls":
+			protocol = proxy.ProtocolTLS
+		case "http":
+			protocol = proxy.ProtocolHTTP
+		case "ssh":
+			protocol = proxy.ProtocolSSH
+		case "smtp":
+			protocol = proxy.ProtocolSMTP
+		default:
+			return nil, fmt.Errorf("invalid sniff protocol %q", c.Sniff)
+		}
+
+		raddr, err := net.ResolveTCPAddr("tcp", c.Remote)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sniff remote %q: %w", c.Remote, err)
+		}
+
+		routes = append(routes, proxy.SniffRoute{
+			Protocol: protocol,
+			Hostname: c.SNI,
+			Remote:   raddr,
+		})
+	}
+	return routes, nil
+}
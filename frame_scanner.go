@@ -0,0 +1,44 @@
+package proxy
+
+import "errors"
+
+// ErrIncomplete is returned by a parse function passed to FrameScanner.Feed
+// to indicate that it was not given enough bytes to make progress. Feed
+// treats this as "come back once more data has arrived" rather than as a
+// real error.
+var ErrIncomplete = errors.New("proxy: incomplete frame")
+
+// FrameScanner buffers bytes fed to it across TCP read boundaries, so a
+// parser that only understands whole protocol frames doesn't have to
+// special-case a frame that arrives split across two Reads.
+type FrameScanner struct {
+	buf []byte
+}
+
+// NewFrameScanner creates an empty FrameScanner.
+func NewFrameScanner() *FrameScanner {
+	return &FrameScanner{}
+}
+
+// Feed appends b to whatever is buffered from previous calls, then
+// repeatedly invokes parse with the buffered bytes until parse returns
+// ErrIncomplete or a real error. parse must report how many leading bytes
+// of its input it consumed; those bytes are dropped from the buffer
+// before parse is called again.
+func (s *FrameScanner) Feed(b []byte, parse func(buffered []byte) (consumed int, err error)) error {
+	s.buf = append(s.buf, b...)
+	for len(s.buf) > 0 {
+		consumed, err := parse(s.buf)
+		if err == ErrIncomplete {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if consumed <= 0 {
+			return nil
+		}
+		s.buf = s.buf[consumed:]
+	}
+	return nil
+}
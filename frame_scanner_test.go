@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+)
+
+// parseLenPrefixed is a toy frame format for exercising FrameScanner on its
+// own: a 1-byte length prefix followed by that many payload bytes.
+func parseLenPrefixed(out *[][]byte) func([]byte) (int, error) {
+	return func(buf []byte) (int, error) {
+		if len(buf) < 1 {
+			return 0, ErrIncomplete
+		}
+		n := int(buf[0])
+		if len(buf) < 1+n {
+			return 0, ErrIncomplete
+		}
+		frame := make([]byte, n)
+		copy(frame, buf[1:1+n])
+		*out = append(*out, frame)
+		return 1 + n, nil
+	}
+}
+
+func TestFrameScannerReassemblesSplitFrame(t *testing.T) {
+	s := NewFrameScanner()
+	var frames [][]byte
+	parse := parseLenPrefixed(&frames)
+
+	full := []byte{5, 'h', 'e', 'l', 'l', 'o'}
+
+	// fed one byte at a time, well short of the declared 5-byte payload
+	for i := 0; i < 3; i++ {
+		if err := s.Feed(full[i:i+1], parse); err != nil {
+			t.Fatalf("Feed: %v", err)
+		}
+	}
+	if len(frames) != 0 {
+		t.Fatalf("frame parsed before enough bytes arrived: %v", frames)
+	}
+
+	// the rest arrives in one read
+	if err := s.Feed(full[3:], parse); err != nil {
+		t.Fatalf("Feed: %v", err)
+	}
+	if len(frames) != 1 || string(frames[0]) != "hello" {
+		t.Fatalf("frames = %v, want one frame %q", frames, "hello")
+	}
+}
+
+func TestFrameScannerHandlesMultipleFramesPerFeed(t *testing.T) {
+	s := NewFrameScanner()
+	var frames [][]byte
+	parse := parseLenPrefixed(&frames)
+
+	buf := append([]byte{3, 'f', 'o', 'o'}, []byte{3, 'b', 'a', 'r'}...)
+	if err := s.Feed(buf, parse); err != nil {
+		t.Fatalf("Feed: %v", err)
+	}
+	if len(frames) != 2 || string(frames[0]) != "foo" || string(frames[1]) != "bar" {
+		t.Fatalf("frames = %v, want [foo bar]", frames)
+	}
+}
+
+// buildAMQPAckFrame hand-encodes a method frame carrying Basic.Ack, in the
+// same wire format amqphelper.FrameReader expects: a 7-byte header (type,
+// channel, payload size), the payload (class id, method id, delivery tag,
+// bits), and a frame-end byte.
+func buildAMQPAckFrame(deliveryTag uint64, multiple bool) []byte {
+	var bits byte
+	if multiple {
+		bits = 1
+	}
+	payload := []byte{
+		0, 60, // class id: basic
+		0, 80, // method id: basic.ack
+		byte(deliveryTag >> 56), byte(deliveryTag >> 48), byte(deliveryTag >> 40), byte(deliveryTag >> 32),
+		byte(deliveryTag >> 24), byte(deliveryTag >> 16), byte(deliveryTag >> 8), byte(deliveryTag),
+		bits,
+	}
+	frame := []byte{
+		1,    // frame type: method
+		0, 0, // channel 0
+		0, 0, 0, byte(len(payload)), // payload size
+	}
+	frame = append(frame, payload...)
+	frame = append(frame, 206) // frame-end
+	return frame
+}
+
+// buildAMQPNackFrame is the Basic.Nack frame amqpInterceptor should rewrite
+// buildAMQPAckFrame into: same delivery tag and Multiple flag, Requeue unset.
+func buildAMQPNackFrame(deliveryTag uint64, multiple bool) []byte {
+	var bits byte
+	if multiple {
+		bits = 1
+	}
+	payload := []byte{
+		0, 60, // class id: basic
+		0, 120, // method id: basic.nack
+		byte(deliveryTag >> 56), byte(deliveryTag >> 48), byte(deliveryTag >> 40), byte(deliveryTag >> 32),
+		byte(deliveryTag >> 24), byte(deliveryTag >> 16), byte(deliveryTag >> 8), byte(deliveryTag),
+		bits,
+	}
+	frame := []byte{
+		1,
+		0, 0,
+		0, 0, 0, byte(len(payload)),
+	}
+	frame = append(frame, payload...)
+	frame = append(frame, 206)
+	return frame
+}
+
+// TestAMQPInterceptorReassemblesSplitFrame confirms the chunk0-2 interceptor
+// reassembles an AMQP frame split across two reads instead of silently
+// passing the fragment through, the exact breakage the request described.
+func TestAMQPInterceptorReassemblesSplitFrame(t *testing.T) {
+	ack := buildAMQPAckFrame(42, false)
+	want := buildAMQPNackFrame(42, false)
+
+	ic := newAMQPInterceptor()
+	var out bytes.Buffer
+
+	split := len(ack) / 2
+	if _, err := ic.Intercept(ClientToServer, ack[:split], &out); err != nil {
+		t.Fatalf("Intercept (first half): %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("wrote output before the frame was complete: % x", out.Bytes())
+	}
+
+	if _, err := ic.Intercept(ClientToServer, ack[split:], &out); err != nil {
+		t.Fatalf("Intercept (second half): %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("rewritten frame = % x, want % x", out.Bytes(), want)
+	}
+}
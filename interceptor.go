@@ -0,0 +1,43 @@
+package proxy
+
+import "io"
+
+// Direction identifies which side of a Proxy connection a chunk of data
+// came from.
+type Direction int
+
+const (
+	// ClientToServer is data read from the local (client) connection,
+	// about to be written to the remote (server) connection.
+	ClientToServer Direction = iota
+	// ServerToClient is data read from the remote (server) connection,
+	// about to be written to the local (client) connection.
+	ServerToClient
+)
+
+// String implements fmt.Stringer.
+func (d Direction) String() string {
+	if d == ServerToClient {
+		return "server->client"
+	}
+	return "client->server"
+}
+
+// Interceptor inspects, and optionally rewrites, traffic flowing through a
+// Proxy. Intercept is handed the bytes most recently read off the wire in
+// direction dir, and should write whatever it wants forwarded to the
+// remote side to out. It returns the number of leading bytes of in it
+// consumed.
+//
+// An Interceptor that needs to reassemble a protocol unit spread across
+// several TCP reads (see FrameScanner) should buffer internally and
+// consume everything it is given, writing to out only once it has a
+// complete unit; Proxy does not carry unconsumed bytes over between calls
+// itself.
+//
+// A single Proxy connection uses one Interceptor instance per configured
+// interceptor for its whole lifetime, so implementations may keep
+// per-connection state (e.g. a FrameScanner per Direction).
+type Interceptor interface {
+	Intercept(dir Direction, in []byte, out io.Writer) (consumed int, err error)
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import "fmt"
+
+// interceptors holds the built-in Interceptor factories, keyed by the name
+// used to select them (e.g. from the CLI's --intercept flag).
+var interceptors = map[string]func() Interceptor{
+	"amqp-nack": newAMQPInterceptor,
+}
+
+// RegisterInterceptor makes an Interceptor factory available under name,
+// so it can be looked up by name with NewInterceptor. It panics if name is
+// already registered.
+func RegisterInterceptor(name string, factory func() Interceptor) {
+	if _, ok := interceptors[name]; ok {
+		panic("proxy: Interceptor already registered: " + name)
+	}
+	interceptors[name] = factory
+}
+
+// NewInterceptor returns a fresh Interceptor instance for a previously
+// registered name.
+func NewInterceptor(name string) (Interceptor, error) {
+	factory, ok := interceptors[name]
+	if !ok {
+		return nil, fmt.Errorf("proxy: unknown interceptor %q", name)
+	}
+	return factory(), nil
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"io"
+	"testing"
+)
+
+// identityInterceptor forwards its input unchanged.
+type identityInterceptor struct{}
+
+func (identityInterceptor) Intercept(dir Direction, in []byte, out io.Writer) (int, error) {
+	n, err := out.Write(in)
+	return n, err
+}
+
+// doublingInterceptor writes each input byte twice, so its output for a
+// given prefix is longer than the input consumed so far -- the condition
+// that corrupted chaining when intercept() reused one buffer in place.
+type doublingInterceptor struct{}
+
+func (doublingInterceptor) Intercept(dir Direction, in []byte, out io.Writer) (int, error) {
+	for _, b := range in {
+		if _, err := out.Write([]byte{b, b}); err != nil {
+			return 0, err
+		}
+	}
+	return len(in), nil
+}
+
+// TestInterceptChaining is the reviewer's repro for the chunk0-2 buffer-reuse
+// bug: chaining identity then doubling over "ABCDEF" must produce
+// "AABBCCDDEEFF", not the previous "AAAAAAAAAAAA".
+func TestInterceptChaining(t *testing.T) {
+	p := &Proxy{
+		Log:          NullLogger{},
+		Interceptors: []Interceptor{identityInterceptor{}, doublingInterceptor{}},
+	}
+
+	got, err := p.intercept(ClientToServer, []byte("ABCDEF"))
+	if err != nil {
+		t.Fatalf("intercept: %v", err)
+	}
+	want := "AABBCCDDEEFF"
+	if string(got) != want {
+		t.Fatalf("intercept chain output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import "sync/atomic"
+
+// ConnState is a lifecycle event reported via Proxy.OnStateChange.
+type ConnState int
+
+const (
+	// ConnOpen is reported once the remote (and any mirror) dials
+	// succeed, just before the connection starts piping data.
+	ConnOpen ConnState = iota
+	// ConnClosed is reported just before Start returns.
+	ConnClosed
+)
+
+// ConnInfo is a point-in-time snapshot of a Proxy's connection state,
+// returned by Info for a connection registry or admin API.
+type ConnInfo struct {
+	ClientAddr    string
+	RemoteAddr    string
+	SentBytes     uint64
+	ReceivedBytes uint64
+	MatchedRules  uint64
+}
+
+// Info returns a snapshot of the connection's current state. It is safe
+// to call concurrently with Start, but -- like Start's own "Closed" log
+// line -- SentBytes/ReceivedBytes are each read without synchronization
+// against the single goroutine that updates them, so they are a
+// best-effort snapshot, not a guaranteed-exact one. MatchedRules, which
+// both directions' goroutines can update, is read atomically.
+func (p *Proxy) Info() ConnInfo {
+	return ConnInfo{
+		ClientAddr:    p.clientAddr,
+		RemoteAddr:    p.remoteAddr,
+		SentBytes:     p.sentBytes,
+		ReceivedBytes: p.receivedBytes,
+		MatchedRules:  atomic.LoadUint64(&p.matchedRules),
+	}
+}
+
+// Stop forcibly closes the connection, as if ctx had been cancelled; the
+// pending Start call returns nil rather than ctx.Err(), since ctx itself
+// was never cancelled. Safe to call at any point in the connection's
+// lifetime, including before Start has dialed a remote.
+func (p *Proxy) Stop() {
+	if p.lconn != nil {
+		p.lconn.Close()
+	}
+	if p.rconn != nil {
+		p.rconn.Close()
+	}
+	if p.mReqConn != nil {
+		p.mReqConn.Close()
+	}
+	if p.mRespConn != nil {
+		p.mRespConn.Close()
+	}
+}
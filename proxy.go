@@ -1,9 +1,22 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
 	"io"
 	"net"
+	"sync"
+	"time"
+)
+
+// defaultSniffBytes and defaultSniffTimeout are used by Start when
+// SniffRoutes is set but SniffBytes/SniffTimeout are left at their zero
+// value.
+const (
+	defaultSniffBytes   = 2048
+	defaultSniffTimeout = 200 * time.Millisecond
 )
 
 // Proxy - Manages a Proxy connection, piping data between local and remote.
@@ -16,18 +29,71 @@ type Proxy struct {
 	mRespAddr     *net.TCPAddr
 	mReqConn      io.ReadWriteCloser
 	mRespConn     io.ReadWriteCloser
-	erred         bool
+	errOnce       sync.Once
 	errsig        chan bool
 	tlsUnwrapp    bool
 	tlsAddress    string
+	clientAddr    string
+	remoteAddr    string
+	matchedRules  uint64
 
 	Matcher  func([]byte)
 	Replacer func([]byte) []byte
 
+	// Interceptors are applied in order, after Matcher/Replacer, to each
+	// direction's traffic; see the Interceptor docs for chaining and
+	// buffering semantics.
+	Interceptors []Interceptor
+
+	// Rules are evaluated in order, after Interceptors, against each
+	// chunk of traffic; the first match's action (replace, respond,
+	// drop or close) decides what happens to the chunk.
+	Rules []*Rule
+
 	// Settings
 	Nagles    bool
 	Log       Logger
 	OutputHex bool
+
+	// SendProxyProtocol, when not ProxyProtocolOff, writes a PROXY
+	// protocol header to rconn -- describing lconn's remote (client) and
+	// local addresses -- before any application bytes, so the upstream
+	// can recover the original client address.
+	SendProxyProtocol ProxyProtocolVersion
+
+	// AcceptProxyProtocol, when true, expects a PROXY protocol header (v1
+	// or v2, auto-detected) at the front of lconn and strips it before any
+	// application bytes are read; the client address it encodes is used
+	// in place of lconn's immediate peer for logging.
+	AcceptProxyProtocol bool
+
+	// SniffRoutes, if non-empty, makes Start peek at lconn's first bytes
+	// before dialing the remote, identify the application protocol, and
+	// dial the first matching route's Remote instead of raddr. The
+	// peeked bytes are replayed to whatever is dialed. A Proxy made with
+	// NewTLSUnwrapped always dials its TLS address; for it a matching
+	// route changes only the remote that is logged and reported by Info.
+	SniffRoutes []SniffRoute
+	// SniffBytes caps how many bytes Start peeks to sniff the protocol;
+	// zero means defaultSniffBytes.
+	SniffBytes int
+	// SniffTimeout caps how long Start waits for SniffBytes to arrive
+	// before sniffing whatever was peeked (possibly nothing) and falling
+	// back to raddr; zero means defaultSniffTimeout.
+	SniffTimeout time.Duration
+
+	// OnStateChange, if set, is called with the connection's own Proxy
+	// as it moves through its lifecycle: ConnOpen once the remote dial
+	// succeeds, and ConnClosed just before Start returns. A single
+	// handler can use this to register/unregister many connections in
+	// an external registry, e.g. for an admin API.
+	OnStateChange func(state ConnState, p *Proxy)
+}
+
+// deadlineSetter is implemented by *net.TCPConn and *tls.Conn; sniffPeek
+// uses it to bound how long it waits for bytes to sniff.
+type deadlineSetter interface {
+	SetReadDeadline(t time.Time) error
 }
 
 // New - Create a new Proxy instance. Takes over local connection passed in,
@@ -39,7 +105,6 @@ func New(lconn *net.TCPConn, laddr, raddr, mReqAddr, mRespAddr *net.TCPAddr) *Pr
 		raddr:     raddr,
 		mReqAddr:  mReqAddr,
 		mRespAddr: mRespAddr,
-		erred:     false,
 		errsig:    make(chan bool),
 		Log:       NullLogger{},
 	}
@@ -59,28 +124,73 @@ type setNoDelayer interface {
 	SetNoDelay(bool) error
 }
 
-// Start - open connection to remote and start proxying data.
-func (p *Proxy) Start() {
+// Start - open connection to remote and start proxying data. Start blocks
+// until the proxy is done: either ctx is cancelled, or one of the
+// connections is closed or errors. If a PROXY protocol header cannot be
+// read or written, or the remote or a mirror cannot be dialed, it returns
+// that error without proxying. Otherwise it returns ctx.Err(): non-nil if
+// ctx was cancelled, nil if not.
+func (p *Proxy) Start(ctx context.Context) error {
 	defer p.lconn.Close()
 
+	srcAddr, dstAddr := connAddrs(p.lconn)
+
 	var err error
+	if p.AcceptProxyProtocol {
+		br := bufio.NewReader(p.lconn)
+		hdrSrc, hdrDst, herr := readProxyProtocolHeader(br)
+		if herr != nil {
+			p.Log.Warn("Failed to read PROXY protocol header: %s", herr)
+			return herr
+		}
+		p.lconn = &bufReadWriteCloser{Reader: br, wc: p.lconn}
+		if hdrSrc != nil {
+			srcAddr = hdrSrc
+		}
+		if hdrDst != nil {
+			dstAddr = hdrDst
+		}
+	}
+	if srcAddr != nil {
+		p.clientAddr = srcAddr.String()
+	}
+
+	//sniff to pick a remote, if configured
+	raddr := p.raddr
+	if len(p.SniffRoutes) > 0 {
+		peeked := p.sniffPeek()
+		if route, result, ok := matchSniffRoute(p.SniffRoutes, peeked); ok {
+			p.Log.Info("Sniffed %s %q, routing to %s", result.Protocol, result.Hostname, route.Remote)
+			raddr = route.Remote
+		}
+	}
+
+	p.remoteAddr = raddr.String()
+
 	//connect to remote
 	if p.tlsUnwrapp {
 		p.rconn, err = tls.Dial("tcp", p.tlsAddress, nil)
 	} else {
-		p.rconn, err = net.DialTCP("tcp", nil, p.raddr)
+		p.rconn, err = net.DialTCP("tcp", nil, raddr)
 	}
 	if err != nil {
 		p.Log.Warn("Remote connection failed: %s", err)
-		return
+		return err
 	}
 	defer p.rconn.Close()
 
+	if p.SendProxyProtocol != ProxyProtocolOff {
+		if err := writeProxyProtocolHeader(p.rconn, p.SendProxyProtocol, srcAddr, dstAddr); err != nil {
+			p.Log.Warn("Failed to write PROXY protocol header: %s", err)
+			return err
+		}
+	}
+
 	if p.mReqAddr != nil {
 		p.mReqConn, err = net.DialTCP("tcp", nil, p.mReqAddr)
 		if err != nil {
 			p.Log.Warn("Mirror requests connection failed: %s", err)
-			return
+			return err
 		}
 		defer p.mReqConn.Close()
 	}
@@ -88,7 +198,7 @@ func (p *Proxy) Start() {
 		p.mRespConn, err = net.DialTCP("tcp", nil, p.mRespAddr)
 		if err != nil {
 			p.Log.Warn("Mirror responses connection failed: %s", err)
-			return
+			return err
 		}
 		defer p.mRespConn.Close()
 	}
@@ -114,7 +224,35 @@ func (p *Proxy) Start() {
 	}
 
 	//display both ends
-	p.Log.Info("Opened %s >>> %s", p.laddr.String(), p.raddr.String())
+	if p.clientAddr != "" {
+		p.Log.Info("Opened %s >>> %s (client %s)", p.laddr.String(), raddr.String(), p.clientAddr)
+	} else {
+		p.Log.Info("Opened %s >>> %s", p.laddr.String(), raddr.String())
+	}
+
+	if p.OnStateChange != nil {
+		p.OnStateChange(ConnOpen, p)
+	}
+
+	//ctx.Done() unblocks the in-flight Reads below by closing every
+	//connection the pipes read from or write to; done stops this goroutine
+	//once the proxy has finished on its own.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.lconn.Close()
+			p.rconn.Close()
+			if p.mReqConn != nil {
+				p.mReqConn.Close()
+			}
+			if p.mRespConn != nil {
+				p.mRespConn.Close()
+			}
+		case <-done:
+		}
+	}()
 
 	//bidirectional copy
 	go p.pipe(p.lconn, p.rconn, p.mReqConn)
@@ -123,22 +261,45 @@ func (p *Proxy) Start() {
 	//wait for close...
 	<-p.errsig
 	p.Log.Info("Closed (%d bytes sent, %d bytes received)", p.sentBytes, p.receivedBytes)
-}
 
-func (p *Proxy) err(s string, err error) {
-	if p.erred {
-		return
+	if p.OnStateChange != nil {
+		p.OnStateChange(ConnClosed, p)
 	}
-	if err != io.EOF {
-		p.Log.Warn(s, err)
+
+	return ctx.Err()
+}
+
+// StartBackground - back-compat wrapper around Start for callers that do
+// not need to control the proxy's lifecycle. It runs with
+// context.Background() (i.e. never cancelled) and logs any resulting error.
+func (p *Proxy) StartBackground() {
+	if err := p.Start(context.Background()); err != nil {
+		p.Log.Warn("Proxy stopped: %s", err)
 	}
-	p.errsig <- true
-	p.erred = true
+}
+
+// err reports a pipe failure and signals Start to return. Both pipe
+// goroutines (and the ctx.Done() watcher, indirectly, by closing the
+// connections they read from) can call this concurrently, so only the
+// first call runs: errsig is unbuffered and Start only ever receives
+// from it once.
+func (p *Proxy) err(s string, err error) {
+	p.errOnce.Do(func() {
+		if err != io.EOF {
+			p.Log.Warn(s, err)
+		}
+		p.errsig <- true
+	})
 }
 
 func (p *Proxy) pipe(src, dst, mirror io.ReadWriter) {
 	islocal := src == p.lconn
 
+	if p.canSplice(src, dst, mirror) {
+		p.splice(src.(*net.TCPConn), dst.(*net.TCPConn), islocal)
+		return
+	}
+
 	var dataDirection string
 	if islocal {
 		dataDirection = ">>> %d bytes sent %s"
@@ -153,8 +314,9 @@ func (p *Proxy) pipe(src, dst, mirror io.ReadWriter) {
 		byteFormat = "%s"
 	}
 
-	//directional copy (64k buffer)
-	buff := make([]byte, 0xffff)
+	//directional copy (64k buffer, pooled across connections)
+	buff := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buff)
 	for {
 		n, err := src.Read(buff)
 		if err != nil {
@@ -173,6 +335,43 @@ func (p *Proxy) pipe(src, dst, mirror io.ReadWriter) {
 			b = p.Replacer(b)
 		}
 
+		//execute interceptors
+		if len(p.Interceptors) > 0 {
+			dir := ServerToClient
+			if islocal {
+				dir = ClientToServer
+			}
+			b, err = p.intercept(dir, b)
+			if err != nil {
+				p.err("Interceptor failed '%s'\n", err)
+				return
+			}
+			if len(b) == 0 {
+				continue
+			}
+		}
+
+		//execute rules
+		if len(p.Rules) > 0 {
+			dir := ServerToClient
+			if islocal {
+				dir = ClientToServer
+			}
+			var closeConn bool
+			b, closeConn, err = p.applyRules(dir, b, src)
+			if err != nil {
+				p.err("Rule failed '%s'\n", err)
+				return
+			}
+			if closeConn {
+				p.err("Rule closed connection\n", io.EOF)
+				return
+			}
+			if len(b) == 0 {
+				continue
+			}
+		}
+
 		//show output
 		p.Log.Debug(dataDirection, n, "")
 		p.Log.Trace(byteFormat, b)
@@ -196,3 +395,82 @@ func (p *Proxy) pipe(src, dst, mirror io.ReadWriter) {
 		}
 	}
 }
+
+// intercept runs b through p.Interceptors in order, feeding each one's
+// output to the next. Each stage gets its own buffer -- reusing one in
+// place would have the next stage read from the same backing array it is
+// still writing into.
+func (p *Proxy) intercept(dir Direction, b []byte) ([]byte, error) {
+	for _, ic := range p.Interceptors {
+		out := new(bytes.Buffer)
+		consumed, err := ic.Intercept(dir, b, out)
+		if err != nil {
+			return nil, err
+		}
+		if consumed != len(b) {
+			p.Log.Warn("Interceptor left %d byte(s) unconsumed; dropping them", len(b)-consumed)
+		}
+		b = out.Bytes()
+	}
+	return b, nil
+}
+
+// sniffPeek peeks up to p.SniffBytes bytes off lconn, waiting at most
+// p.SniffTimeout for them to arrive, and wraps lconn so the peeked bytes
+// are replayed to the next reader -- including the upstream, once
+// dialed. A short or empty peek (e.g. on timeout) is not an error:
+// matchSniffRoute simply has less to go on and falls back to raddr.
+func (p *Proxy) sniffPeek() []byte {
+	n := p.SniffBytes
+	if n <= 0 {
+		n = defaultSniffBytes
+	}
+	timeout := p.SniffTimeout
+	if timeout <= 0 {
+		timeout = defaultSniffTimeout
+	}
+
+	if dl, ok := p.lconn.(deadlineSetter); ok {
+		dl.SetReadDeadline(time.Now().Add(timeout))
+		defer dl.SetReadDeadline(time.Time{})
+	}
+
+	// sized to n: bufio.NewReader's default 4096-byte buffer silently caps
+	// Peek at 4096 regardless of n, so SniffBytes above that would never
+	// actually be honoured.
+	br := bufio.NewReaderSize(p.lconn, n)
+	peeked, _ := br.Peek(n)
+	p.lconn = &bufReadWriteCloser{Reader: br, wc: p.lconn}
+	return peeked
+}
+
+// canSplice reports whether src/dst can be copied with io.Copy instead of
+// the inspecting read/write loop: that requires both ends to be plain TCP
+// connections (so io.Copy's ReaderFrom path can use splice(2) on Linux)
+// and nothing configured that needs to see the bytes in userspace.
+func (p *Proxy) canSplice(src, dst, mirror io.ReadWriter) bool {
+	if mirror != nil || p.Matcher != nil || p.Replacer != nil || p.OutputHex || len(p.Interceptors) > 0 || len(p.Rules) > 0 {
+		return false
+	}
+	if _, ok := p.Log.(NullLogger); !ok {
+		return false
+	}
+	_, srcOK := src.(*net.TCPConn)
+	_, dstOK := dst.(*net.TCPConn)
+	return srcOK && dstOK
+}
+
+// splice copies src to dst with io.Copy, letting the Go runtime use
+// splice(2) on Linux to move bytes without passing through userspace.
+func (p *Proxy) splice(src, dst *net.TCPConn, islocal bool) {
+	n, err := io.Copy(dst, src)
+	if islocal {
+		p.sentBytes += uint64(n)
+	} else {
+		p.receivedBytes += uint64(n)
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	p.err("Read failed '%s'\n", err)
+}
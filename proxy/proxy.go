@@ -1,134 +0,0 @@
-package proxy
-
-import (
-	"io"
-	"net"
-)
-
-// Proxy - Manages a Proxy connection, piping data between local and remote.
-type Proxy struct {
-	sentBytes     uint64
-	receivedBytes uint64
-	laddr, raddr  *net.TCPAddr
-	lconn, rconn  *net.TCPConn
-	erred         bool
-	errsig        chan bool
-
-	Matcher  func([]byte)
-	Replacer func([]byte) []byte
-
-	// Settings
-	Nagles    bool
-	Log       Logger
-	OutputHex bool
-}
-
-// New - Create a new Proxy instance. Takes over local connection passed in,
-// and closes it when finished.
-func New(lconn *net.TCPConn, laddr, raddr *net.TCPAddr) *Proxy {
-	return &Proxy{
-		lconn:  lconn,
-		laddr:  laddr,
-		raddr:  raddr,
-		erred:  false,
-		errsig: make(chan bool),
-		Log:    NullLogger{},
-	}
-}
-
-// Start - open connection to remote and start proxying data.
-func (p *Proxy) Start() {
-	defer p.lconn.Close()
-
-	//connect to remote
-	rconn, err := net.DialTCP("tcp", nil, p.raddr)
-	if err != nil {
-		p.err("Remote connection failed: %s", err)
-		return
-	}
-	p.rconn = rconn
-	defer p.rconn.Close()
-
-	//nagles?
-	if p.Nagles {
-		p.lconn.SetNoDelay(true)
-		p.rconn.SetNoDelay(true)
-	}
-
-	//display both ends
-	p.Log.Info("Opened %s >>> %s", p.lconn.RemoteAddr().String(), p.rconn.RemoteAddr().String())
-
-	//bidirectional copy
-	go p.pipe(p.lconn, p.rconn)
-	go p.pipe(p.rconn, p.lconn)
-
-	//wait for close...
-	<-p.errsig
-	p.Log.Info("Closed (%d bytes sent, %d bytes recieved)", p.sentBytes, p.receivedBytes)
-}
-
-func (p *Proxy) err(s string, err error) {
-	if p.erred {
-		return
-	}
-	if err != io.EOF {
-		p.Log.Warn(s, err)
-	}
-	p.errsig <- true
-	p.erred = true
-}
-
-func (p *Proxy) pipe(src, dst *net.TCPConn) {
-	islocal := src == p.lconn
-
-	var dataDirection string
-	if islocal {
-		dataDirection = ">>> %d bytes sent%s"
-	} else {
-		dataDirection = "<<< %d bytes recieved%s"
-	}
-
-	var byteFormat string
-	if p.OutputHex {
-		byteFormat = "%x"
-	} else {
-		byteFormat = "%s"
-	}
-
-	//directional copy (64k buffer)
-	buff := make([]byte, 0xffff)
-	for {
-		n, err := src.Read(buff)
-		if err != nil {
-			p.err("Read failed '%s'\n", err)
-			return
-		}
-		b := buff[:n]
-
-		//execute match
-		if p.Matcher != nil {
-			p.Matcher(b)
-		}
-
-		//execute replace
-		if p.Replacer != nil {
-			b = p.Replacer(b)
-		}
-
-		//show output
-		p.Log.Debug(dataDirection, n, "")
-		p.Log.Trace(byteFormat, b)
-
-		//write out result
-		n, err = dst.Write(b)
-		if err != nil {
-			p.err("Write failed '%s'\n", err)
-			return
-		}
-		if islocal {
-			p.sentBytes += uint64(n)
-		} else {
-			p.receivedBytes += uint64(n)
-		}
-	}
-}
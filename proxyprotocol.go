@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyProtocolVersion selects whether, and in which version, a Proxy
+// writes (or expects to read) a HAProxy PROXY protocol header, used to
+// carry the original client address across a hop that would otherwise
+// lose it.
+type ProxyProtocolVersion int
+
+const (
+	// ProxyProtocolOff sends/expects no PROXY protocol header.
+	ProxyProtocolOff ProxyProtocolVersion = iota
+	// ProxyProtocolV1 is the human-readable text header.
+	ProxyProtocolV1
+	// ProxyProtocolV2 is the compact binary header.
+	ProxyProtocolV2
+)
+
+// ParseProxyProtocolVersion parses the CLI-facing spelling of a
+// ProxyProtocolVersion: "", "off", "v1" or "v2".
+func ParseProxyProtocolVersion(s string) (ProxyProtocolVersion, error) {
+	switch s {
+	case "", "off":
+		return ProxyProtocolOff, nil
+	case "v1":
+		return ProxyProtocolV1, nil
+	case "v2":
+		return ProxyProtocolV2, nil
+	default:
+		return ProxyProtocolOff, fmt.Errorf("proxy: unknown proxy-protocol version %q", s)
+	}
+}
+
+var proxyProtocolV2Signature = []byte{
+	0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A,
+}
+
+// connAddrs returns the TCP addresses of a connection, or nil, nil if c
+// doesn't carry them (e.g. it isn't a net.Conn).
+func connAddrs(c io.ReadWriteCloser) (src, dst *net.TCPAddr) {
+	conn, ok := c.(net.Conn)
+	if !ok {
+		return nil, nil
+	}
+	src, _ = conn.RemoteAddr().(*net.TCPAddr)
+	dst, _ = conn.LocalAddr().(*net.TCPAddr)
+	return src, dst
+}
+
+// writeProxyProtocolHeader writes a PROXY protocol header describing src
+// and dst to w, in the given version. It is a no-op for ProxyProtocolOff.
+func writeProxyProtocolHeader(w io.Writer, version ProxyProtocolVersion, src, dst *net.TCPAddr) error {
+	if version != ProxyProtocolOff && (src == nil || dst == nil) {
+		return errors.New("proxy: cannot write PROXY protocol header without src/dst addresses")
+	}
+	switch version {
+	case ProxyProtocolOff:
+		return nil
+	case ProxyProtocolV1:
+		return writeProxyProtocolV1(w, src, dst)
+	case ProxyProtocolV2:
+		return writeProxyProtocolV2(w, src, dst)
+	default:
+		return fmt.Errorf("proxy: unknown proxy-protocol version %d", version)
+	}
+}
+
+func writeProxyProtocolV1(w io.Writer, src, dst *net.TCPAddr) error {
+	family := "TCP4"
+	if src.IP.To4() == nil {
+		family = "TCP6"
+	}
+	_, err := fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", family, src.IP.String(), dst.IP.String(), src.Port, dst.Port)
+	return err
+}
+
+func writeProxyProtocolV2(w io.Writer, src, dst *net.TCPAddr) error {
+	srcIP4, dstIP4 := src.IP.To4(), dst.IP.To4()
+
+	var famProto byte
+	var addrs []byte
+	if srcIP4 != nil && dstIP4 != nil {
+		famProto = 0x11 // AF_INET << 4 | STREAM
+		addrs = append(addrs, srcIP4...)
+		addrs = append(addrs, dstIP4...)
+	} else {
+		famProto = 0x21 // AF_INET6 << 4 | STREAM
+		addrs = append(addrs, src.IP.To16()...)
+		addrs = append(addrs, dst.IP.To16()...)
+	}
+	addrs = append(addrs, byte(src.Port>>8), byte(src.Port), byte(dst.Port>>8), byte(dst.Port))
+
+	header := make([]byte, 0, len(proxyProtocolV2Signature)+4+len(addrs))
+	header = append(header, proxyProtocolV2Signature...)
+	header = append(header, 0x21) // version 2, command PROXY
+	header = append(header, famProto)
+	header = append(header, byte(len(addrs)>>8), byte(len(addrs)))
+	header = append(header, addrs...)
+
+	_, err := w.Write(header)
+	return err
+}
+
+// readProxyProtocolHeader reads and parses a PROXY protocol v1 or v2
+// header (auto-detected) from the front of br, returning the original
+// client and destination addresses it encodes. br must wrap the raw
+// connection so bytes it has buffered but not returned aren't lost.
+func readProxyProtocolHeader(br *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	peek, err := br.Peek(len(proxyProtocolV2Signature))
+	if err == nil && bytes.Equal(peek, proxyProtocolV2Signature) {
+		return readProxyProtocolV2(br)
+	}
+	return readProxyProtocolV1(br)
+}
+
+func readProxyProtocolV1(br *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := strings.Fields(strings.TrimRight(line, "\r\n"))
+	if len(fields) != 6 || fields[0] != "PROXY" {
+		return nil, nil, fmt.Errorf("proxy: malformed PROXY protocol v1 header %q", line)
+	}
+	srcPort, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, nil, fmt.Errorf("proxy: malformed PROXY protocol v1 source port: %w", err)
+	}
+	dstPort, err := strconv.Atoi(fields[5])
+	if err != nil {
+		return nil, nil, fmt.Errorf("proxy: malformed PROXY protocol v1 destination port: %w", err)
+	}
+	src = &net.TCPAddr{IP: net.ParseIP(fields[2]), Port: srcPort}
+	dst = &net.TCPAddr{IP: net.ParseIP(fields[3]), Port: dstPort}
+	return src, dst, nil
+}
+
+func readProxyProtocolV2(br *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	header := make([]byte, 16)
+	if _, err = io.ReadFull(br, header); err != nil {
+		return nil, nil, err
+	}
+	famProto := header[13]
+	addrLen := int(binary.BigEndian.Uint16(header[14:16]))
+	addrs := make([]byte, addrLen)
+	if _, err = io.ReadFull(br, addrs); err != nil {
+		return nil, nil, err
+	}
+
+	switch famProto {
+	case 0x11: // TCP over IPv4
+		if addrLen < 12 {
+			return nil, nil, errors.New("proxy: short PROXY protocol v2 IPv4 address block")
+		}
+		src = &net.TCPAddr{IP: net.IP(addrs[0:4]), Port: int(binary.BigEndian.Uint16(addrs[8:10]))}
+		dst = &net.TCPAddr{IP: net.IP(addrs[4:8]), Port: int(binary.BigEndian.Uint16(addrs[10:12]))}
+	case 0x21: // TCP over IPv6
+		if addrLen < 36 {
+			return nil, nil, errors.New("proxy: short PROXY protocol v2 IPv6 address block")
+		}
+		src = &net.TCPAddr{IP: net.IP(addrs[0:16]), Port: int(binary.BigEndian.Uint16(addrs[32:34]))}
+		dst = &net.TCPAddr{IP: net.IP(addrs[16:32]), Port: int(binary.BigEndian.Uint16(addrs[34:36]))}
+	default:
+		// LOCAL command, or a family/protocol this proxy doesn't carry an
+		// address for -- nothing to recover.
+		return nil, nil, nil
+	}
+	return src, dst, nil
+}
+
+// bufReadWriteCloser adapts a *bufio.Reader (used to peek/consume a PROXY
+// protocol header without losing any buffered application bytes behind
+// it) back into an io.ReadWriteCloser over the original connection.
+type bufReadWriteCloser struct {
+	*bufio.Reader
+	wc io.WriteCloser
+}
+
+func (b *bufReadWriteCloser) Write(p []byte) (int, error) { return b.wc.Write(p) }
+func (b *bufReadWriteCloser) Close() error                { return b.wc.Close() }
+
+// SetReadDeadline forwards to the wrapped connection so callers -- sniffPeek
+// in particular -- can still bound a Peek/Read through this wrapper. Without
+// this, wrapping a connection here (e.g. after accepting a PROXY protocol
+// header) silently defeats any deadlineSetter type assertion made against it.
+func (b *bufReadWriteCloser) SetReadDeadline(t time.Time) error {
+	if ds, ok := b.wc.(deadlineSetter); ok {
+		return ds.SetReadDeadline(t)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestWriteProxyProtocolV1(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 443}
+
+	var buf bytes.Buffer
+	if err := writeProxyProtocolHeader(&buf, ProxyProtocolV1, src, dst); err != nil {
+		t.Fatalf("writeProxyProtocolHeader: %v", err)
+	}
+
+	want := "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("header = %q, want %q", got, want)
+	}
+}
+
+func TestWriteProxyProtocolV1IPv6(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("::2"), Port: 443}
+
+	var buf bytes.Buffer
+	if err := writeProxyProtocolHeader(&buf, ProxyProtocolV1, src, dst); err != nil {
+		t.Fatalf("writeProxyProtocolHeader: %v", err)
+	}
+
+	want := "PROXY TCP6 ::1 ::2 56324 443\r\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("header = %q, want %q", got, want)
+	}
+}
+
+func TestWriteProxyProtocolV2(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 443}
+
+	var buf bytes.Buffer
+	if err := writeProxyProtocolHeader(&buf, ProxyProtocolV2, src, dst); err != nil {
+		t.Fatalf("writeProxyProtocolHeader: %v", err)
+	}
+
+	want := []byte{
+		// signature
+		0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A,
+		0x21,       // version 2, command PROXY
+		0x11,       // AF_INET << 4 | STREAM
+		0x00, 0x0C, // address block length: 12 bytes
+		192, 168, 0, 1, // src IP
+		192, 168, 0, 11, // dst IP
+		0xDC, 0x04, // src port 56324
+		0x01, 0xBB, // dst port 443
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("header = % x, want % x", got, want)
+	}
+}
+
+func TestWriteProxyProtocolOff(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 2}
+
+	var buf bytes.Buffer
+	if err := writeProxyProtocolHeader(&buf, ProxyProtocolOff, src, dst); err != nil {
+		t.Fatalf("writeProxyProtocolHeader: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no bytes written for ProxyProtocolOff, got % x", buf.Bytes())
+	}
+}
+
+func TestReadProxyProtocolV1(t *testing.T) {
+	raw := "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n"
+	br := bufio.NewReader(bytes.NewBufferString(raw))
+
+	src, dst, err := readProxyProtocolHeader(br)
+	if err != nil {
+		t.Fatalf("readProxyProtocolHeader: %v", err)
+	}
+	if src.String() != "192.168.0.1:56324" {
+		t.Fatalf("src = %s, want 192.168.0.1:56324", src)
+	}
+	if dst.String() != "192.168.0.11:443" {
+		t.Fatalf("dst = %s, want 192.168.0.11:443", dst)
+	}
+
+	rest, _ := br.ReadString('\n')
+	if rest != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("bytes after header = %q, want %q", rest, "GET / HTTP/1.1\r\n")
+	}
+}
+
+func TestReadProxyProtocolV2RoundTrip(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 12345}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.6"), Port: 80}
+
+	var buf bytes.Buffer
+	if err := writeProxyProtocolHeader(&buf, ProxyProtocolV2, src, dst); err != nil {
+		t.Fatalf("writeProxyProtocolHeader: %v", err)
+	}
+	buf.WriteString("trailing application data")
+
+	br := bufio.NewReader(&buf)
+	gotSrc, gotDst, err := readProxyProtocolHeader(br)
+	if err != nil {
+		t.Fatalf("readProxyProtocolHeader: %v", err)
+	}
+	if gotSrc.String() != src.String() {
+		t.Fatalf("src = %s, want %s", gotSrc, src)
+	}
+	if gotDst.String() != dst.String() {
+		t.Fatalf("dst = %s, want %s", gotDst, dst)
+	}
+
+	rest, _ := br.ReadString(0)
+	if rest != "trailing application data" {
+		t.Fatalf("bytes after header = %q", rest)
+	}
+}
+
+func TestReadProxyProtocolV1Malformed(t *testing.T) {
+	br := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.168.0.1\r\n"))
+	if _, _, err := readProxyProtocolHeader(br); err == nil {
+		t.Fatal("expected an error for a malformed v1 header")
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync/atomic"
+)
+
+// RuleAction is what a matching Rule does to the chunk it matched.
+type RuleAction int
+
+const (
+	// ActionReplace rewrites the matched chunk with Rule.Replacement.
+	ActionReplace RuleAction = iota
+	// ActionRespond writes Rule.Response back to the connection the
+	// chunk came from, instead of forwarding the chunk onward.
+	ActionRespond
+	// ActionDrop discards the chunk; nothing is forwarded.
+	ActionDrop
+	// ActionClose terminates the connection; nothing is forwarded.
+	ActionClose
+)
+
+// Rule is one entry of a Proxy's rule set. Rules are evaluated in order
+// against each chunk of traffic; the first one that matches decides what
+// happens to the chunk, and the rest are skipped.
+type Rule struct {
+	// Name identifies the rule in the connection log.
+	Name string
+
+	// Match and MatchContains are mutually exclusive ways to test a
+	// chunk; a Rule with neither set never matches.
+	Match         *regexp.Regexp
+	MatchContains []byte
+
+	// Direction restricts the rule to one side of the connection; nil
+	// matches both directions.
+	Direction *Direction
+
+	Action RuleAction
+
+	// Replacement is used by ActionReplace: it replaces the matched
+	// chunk wholesale (not just the matched substring).
+	Replacement []byte
+
+	// Response is used by ActionRespond: it is written back to the
+	// connection the matching chunk came from.
+	Response []byte
+
+	// CloseAfterRespond, with ActionRespond, closes the connection once
+	// Response has been written.
+	CloseAfterRespond bool
+}
+
+func (r *Rule) matches(dir Direction, b []byte) bool {
+	if r.Direction != nil && *r.Direction != dir {
+		return false
+	}
+	if r.Match != nil {
+		return r.Match.Match(b)
+	}
+	if r.MatchContains != nil {
+		return bytes.Contains(b, r.MatchContains)
+	}
+	return false
+}
+
+// applyRules evaluates p.Rules against b, in order, and applies the
+// first match. origin is where a respond action writes its canned
+// response -- the connection b was just read from. It returns the bytes
+// to forward to dst in place of b, and whether the connection should now
+// be closed.
+func (p *Proxy) applyRules(dir Direction, b []byte, origin io.Writer) ([]byte, bool, error) {
+	for _, r := range p.Rules {
+		if !r.matches(dir, b) {
+			continue
+		}
+		// both directions' pipe goroutines can reach this concurrently
+		atomic.AddUint64(&p.matchedRules, 1)
+		p.Log.Info("Rule %q matched (%s)", r.Name, dir)
+		switch r.Action {
+		case ActionReplace:
+			return r.Replacement, false, nil
+		case ActionRespond:
+			if _, err := origin.Write(r.Response); err != nil {
+				return nil, false, err
+			}
+			return nil, r.CloseAfterRespond, nil
+		case ActionDrop:
+			return nil, false, nil
+		case ActionClose:
+			return nil, true, nil
+		}
+	}
+	return b, false, nil
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+// Protocol identifies an application protocol detected by sniff.
+type Protocol string
+
+// Protocols sniff knows how to detect.
+const (
+	ProtocolUnknown Protocol = ""
+	ProtocolTLS     Protocol = "tls"
+	ProtocolHTTP    Protocol = "http"
+	ProtocolSSH     Protocol = "ssh"
+	ProtocolSMTP    Protocol = "smtp"
+)
+
+// SniffResult is what sniff found in a chunk of freshly accepted client
+// bytes.
+type SniffResult struct {
+	Protocol Protocol
+	// Hostname is the TLS ClientHello SNI or HTTP Host header value,
+	// when the protocol carries one; otherwise empty.
+	Hostname string
+}
+
+// SniffRoute is one routing rule evaluated against a connection's
+// SniffResult: on match, Remote replaces the Proxy's default raddr for
+// that connection.
+type SniffRoute struct {
+	Protocol Protocol
+	// Hostname, if set, must equal (or match a leading "*." wildcard
+	// against) the sniffed hostname for this route to apply. Left
+	// empty, any (or no) hostname matches.
+	Hostname string
+	Remote   *net.TCPAddr
+}
+
+// sniff identifies the application protocol of b, the first bytes read
+// off a freshly accepted connection. It never errors: bytes that match
+// nothing recognized yield ProtocolUnknown.
+func sniff(b []byte) SniffResult {
+	if host, ok := parseTLSClientHello(b); ok {
+		return SniffResult{Protocol: ProtocolTLS, Hostname: host}
+	}
+	if host, ok := parseHTTPRequest(b); ok {
+		return SniffResult{Protocol: ProtocolHTTP, Hostname: host}
+	}
+	if bytes.HasPrefix(b, []byte("SSH-")) {
+		return SniffResult{Protocol: ProtocolSSH}
+	}
+	upper := bytes.ToUpper(b)
+	if bytes.HasPrefix(upper, []byte("EHLO ")) || bytes.HasPrefix(upper, []byte("HELO ")) {
+		return SniffResult{Protocol: ProtocolSMTP}
+	}
+	return SniffResult{Protocol: ProtocolUnknown}
+}
+
+// matchSniffRoute sniffs peeked and returns the first of routes whose
+// Protocol and Hostname match.
+func matchSniffRoute(routes []SniffRoute, peeked []byte) (route SniffRoute, result SniffResult, ok bool) {
+	result = sniff(peeked)
+	for _, r := range routes {
+		if r.Protocol != result.Protocol {
+			continue
+		}
+		if !hostnameMatches(r.Hostname, result.Hostname) {
+			continue
+		}
+		return r, result, true
+	}
+	return SniffRoute{}, result, false
+}
+
+func hostnameMatches(pattern, host string) bool {
+	if pattern == "" {
+		return true
+	}
+	if host == "" {
+		return false
+	}
+	if rest, ok := strings.CutPrefix(pattern, "*."); ok {
+		suffix := "." + rest
+		return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+	}
+	return strings.EqualFold(pattern, host)
+}
+
+// parseTLSClientHello reports whether b looks like the start of a TLS
+// ClientHello record, and if so extracts its SNI server name (empty if
+// absent or not yet buffered).
+func parseTLSClientHello(b []byte) (hostname string, ok bool) {
+	// record header: type(1)=handshake version(2) length(2)
+	// handshake header: type(1)=client_hello length(3)
+	if len(b) < 6 || b[0] != 0x16 || b[5] != 0x01 {
+		return "", false
+	}
+
+	i := 5 + 4 // record header + handshake header
+	i += 2     // client version
+	i += 32    // random
+	if i+1 > len(b) {
+		return "", true
+	}
+	i += 1 + int(b[i]) // session id
+	if i+2 > len(b) {
+		return "", true
+	}
+	i += 2 + (int(b[i])<<8 | int(b[i+1])) // cipher suites
+	if i+1 > len(b) {
+		return "", true
+	}
+	i += 1 + int(b[i]) // compression methods
+	if i+2 > len(b) {
+		return "", true
+	}
+	extEnd := i + 2 + (int(b[i])<<8 | int(b[i+1]))
+	i += 2
+	if extEnd > len(b) {
+		extEnd = len(b)
+	}
+
+	for i+4 <= extEnd {
+		extType := int(b[i])<<8 | int(b[i+1])
+		extLen := int(b[i+2])<<8 | int(b[i+3])
+		i += 4
+		if i+extLen > extEnd {
+			break
+		}
+		if extType == 0x0000 { // server_name
+			if name, ok := parseSNIExtension(b[i : i+extLen]); ok {
+				return name, true
+			}
+		}
+		i += extLen
+	}
+	return "", true
+}
+
+func parseSNIExtension(body []byte) (string, bool) {
+	// server_name_list length(2) name_type(1)=host_name name_length(2) name
+	if len(body) < 5 {
+		return "", false
+	}
+	nameLen := int(body[3])<<8 | int(body[4])
+	if 5+nameLen > len(body) {
+		return "", false
+	}
+	return string(body[5 : 5+nameLen]), true
+}
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("HEAD "), []byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+}
+
+// parseHTTPRequest reports whether b starts with an HTTP/1.x request
+// line, and if so extracts its Host header (empty if absent or not yet
+// buffered).
+func parseHTTPRequest(b []byte) (hostname string, ok bool) {
+	lines := bytes.Split(b, []byte("\r\n"))
+	if len(lines) == 0 || !looksLikeHTTPRequestLine(lines[0]) {
+		return "", false
+	}
+	for _, line := range lines[1:] {
+		if len(line) == 0 {
+			break
+		}
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 || !bytes.EqualFold(bytes.TrimSpace(line[:i]), []byte("host")) {
+			continue
+		}
+		host := string(bytes.TrimSpace(line[i+1:]))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return host, true
+	}
+	return "", true
+}
+
+func looksLikeHTTPRequestLine(line []byte) bool {
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(line, m) {
+			return bytes.Contains(line, []byte(" HTTP/"))
+		}
+	}
+	return false
+}
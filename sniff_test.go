@@ -0,0 +1,117 @@
+package proxy
+
+import "testing"
+
+// buildClientHello hand-encodes a minimal TLS ClientHello record carrying a
+// single server_name extension for hostname, exercising the same byte
+// offsets parseTLSClientHello walks: record header, handshake header,
+// version, random, session id, cipher suites, compression methods, then the
+// extensions block.
+func buildClientHello(hostname string) []byte {
+	var sni []byte
+	sni = append(sni, 0, 0) // server_name_list length, filled in below
+	sni = append(sni, 0)    // name_type: host_name
+	sni = append(sni, byte(len(hostname)>>8), byte(len(hostname)))
+	sni = append(sni, hostname...)
+	listLen := len(sni) - 2
+	sni[0] = byte(listLen >> 8)
+	sni[1] = byte(listLen)
+
+	var ext []byte
+	ext = append(ext, 0, 0) // extension type: server_name
+	ext = append(ext, byte(len(sni)>>8), byte(len(sni)))
+	ext = append(ext, sni...)
+
+	var body []byte
+	body = append(body, 3, 3)                // client version: TLS 1.2
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session id length: 0
+	body = append(body, 0, 2, 0, 0xff)       // cipher suites: length 2, one suite
+	body = append(body, 1, 0)                // compression methods: length 1, null
+	body = append(body, byte(len(ext)>>8), byte(len(ext)))
+	body = append(body, ext...)
+
+	handshake := append([]byte{0x01, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+
+	record := append([]byte{0x16, 3, 3, byte(len(handshake) >> 8), byte(len(handshake))}, handshake...)
+	return record
+}
+
+func TestParseTLSClientHelloExtractsSNI(t *testing.T) {
+	b := buildClientHello("example.com")
+	host, ok := parseTLSClientHello(b)
+	if !ok {
+		t.Fatalf("parseTLSClientHello: not recognized as a ClientHello")
+	}
+	if host != "example.com" {
+		t.Fatalf("host = %q, want %q", host, "example.com")
+	}
+}
+
+func TestParseTLSClientHelloTruncatedWantsMoreBytes(t *testing.T) {
+	full := buildClientHello("example.com")
+	// Truncate inside the client version: still a ClientHello, just not
+	// enough of it buffered yet to reach the server_name extension.
+	b := full[:10]
+	host, ok := parseTLSClientHello(b)
+	if !ok {
+		t.Fatalf("parseTLSClientHello: truncated ClientHello should still be recognized (ok=true, empty host) so the caller keeps buffering")
+	}
+	if host != "" {
+		t.Fatalf("host = %q, want empty (not enough bytes buffered yet)", host)
+	}
+}
+
+func TestParseTLSClientHelloRejectsNonHandshake(t *testing.T) {
+	b := []byte{0x17, 3, 3, 0, 5, 1, 2, 3, 4, 5} // application_data record
+	if _, ok := parseTLSClientHello(b); ok {
+		t.Fatalf("parseTLSClientHello: accepted a non-handshake record")
+	}
+}
+
+func TestParseHTTPRequestExtractsHost(t *testing.T) {
+	req := "GET /index.html HTTP/1.1\r\nHost: example.com:8080\r\nUser-Agent: test\r\n\r\n"
+	host, ok := parseHTTPRequest([]byte(req))
+	if !ok {
+		t.Fatalf("parseHTTPRequest: not recognized as an HTTP request")
+	}
+	if host != "example.com" {
+		t.Fatalf("host = %q, want %q (port should be stripped)", host, "example.com")
+	}
+}
+
+func TestParseHTTPRequestNoHostHeaderYet(t *testing.T) {
+	// A request line has arrived but the headers haven't finished buffering
+	// -- still a recognized HTTP request, just no hostname extracted yet.
+	req := "POST /submit HTTP/1.1\r\n"
+	host, ok := parseHTTPRequest([]byte(req))
+	if !ok {
+		t.Fatalf("parseHTTPRequest: not recognized as an HTTP request")
+	}
+	if host != "" {
+		t.Fatalf("host = %q, want empty (no Host header buffered yet)", host)
+	}
+}
+
+func TestParseHTTPRequestRejectsNonHTTP(t *testing.T) {
+	if _, ok := parseHTTPRequest([]byte("SSH-2.0-OpenSSH_8.9\r\n")); ok {
+		t.Fatalf("parseHTTPRequest: accepted a non-HTTP line")
+	}
+}
+
+func TestSniffDispatchesToTLSAndHTTP(t *testing.T) {
+	tlsResult := sniff(buildClientHello("tls.example.com"))
+	if tlsResult.Protocol != ProtocolTLS || tlsResult.Hostname != "tls.example.com" {
+		t.Fatalf("sniff(ClientHello) = %+v, want TLS/tls.example.com", tlsResult)
+	}
+
+	httpResult := sniff([]byte("GET / HTTP/1.1\r\nHost: http.example.com\r\n\r\n"))
+	if httpResult.Protocol != ProtocolHTTP || httpResult.Hostname != "http.example.com" {
+		t.Fatalf("sniff(HTTP request) = %+v, want HTTP/http.example.com", httpResult)
+	}
+
+	unknown := sniff([]byte("not a recognized protocol"))
+	if unknown.Protocol != ProtocolUnknown {
+		t.Fatalf("sniff(garbage) = %+v, want ProtocolUnknown", unknown)
+	}
+}